@@ -0,0 +1,222 @@
+package mysqlfe
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dataux/dataux/models"
+	"github.com/dataux/dataux/vendored/mixer/mysql"
+)
+
+// AuthProvider authenticates a connection beyond the legacy
+// mysql_native_password handshake dataux has always spoken. dataux ships
+// mysql_native_password and caching_sha2_password (required by MySQL 8
+// clients by default); operators plug in LDAP/PAM/JWT by registering
+// their own and adding it to models.Config.AuthProviders.
+type AuthProvider interface {
+	// Name is the auth plugin name advertised in the handshake, eg
+	// "mysql_native_password" or "caching_sha2_password".
+	Name() string
+	// Authenticate verifies authResponse against scramble for this plugin
+	// and, on success, returns the ACL for user.
+	Authenticate(user string, authResponse, scramble []byte) (*ACL, error)
+}
+
+// ACL is what an authenticated user is allowed to do: which schemas they
+// may USE, and whether they're restricted to read-only statements.
+type ACL struct {
+	User           string
+	Password       string // cleartext, from models.Config; never sent over the wire
+	AllowedSchemas []string
+	ReadOnly       bool
+}
+
+// allowsSchema reports whether db is reachable under this ACL. A nil ACL,
+// or one with no AllowedSchemas, allows every schema - this keeps
+// behavior unchanged for deployments that don't configure per-user ACLs.
+func (a *ACL) allowsSchema(db string) bool {
+	if a == nil || len(a.AllowedSchemas) == 0 {
+		return true
+	}
+	for _, s := range a.AllowedSchemas {
+		if s == db {
+			return true
+		}
+	}
+	return false
+}
+
+// aclProvider resolves a connection's ACL from its authenticated
+// username. MySqlHandlerShared implements this from models.Config.Users.
+type aclProvider interface {
+	ACLFor(user string) *ACL
+}
+
+// nativePasswordAuth is the legacy mysql_native_password plugin:
+// SHA1(password) XOR SHA1(scramble + SHA1(SHA1(password))).
+type nativePasswordAuth struct{ acls aclProvider }
+
+func (nativePasswordAuth) Name() string { return "mysql_native_password" }
+
+func (a nativePasswordAuth) Authenticate(user string, authResponse, scramble []byte) (*ACL, error) {
+	if a.acls == nil {
+		return nil, nil
+	}
+	acl := a.acls.ACLFor(user)
+	if acl == nil {
+		return nil, mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, fmt.Sprintf("unknown user %s", user))
+	}
+	expected := mysql.CalcPassword(scramble, []byte(acl.Password))
+	if subtle.ConstantTimeCompare(expected, authResponse) != 1 {
+		return nil, mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, fmt.Sprintf("access denied for user %s", user))
+	}
+	return acl, nil
+}
+
+// cachingSha2PasswordAuth implements caching_sha2_password, the default
+// auth plugin MySQL 8 clients expect:
+// SHA256(password) XOR SHA256(SHA256(SHA256(password)) + scramble).
+type cachingSha2PasswordAuth struct{ acls aclProvider }
+
+func (cachingSha2PasswordAuth) Name() string { return "caching_sha2_password" }
+
+func (a cachingSha2PasswordAuth) Authenticate(user string, authResponse, scramble []byte) (*ACL, error) {
+	if a.acls == nil {
+		return nil, nil
+	}
+	acl := a.acls.ACLFor(user)
+	if acl == nil {
+		return nil, mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, fmt.Sprintf("unknown user %s", user))
+	}
+	expected := scrambleSha256(acl.Password, scramble)
+	if subtle.ConstantTimeCompare(expected, authResponse) != 1 {
+		return nil, mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR, fmt.Sprintf("access denied for user %s", user))
+	}
+	return acl, nil
+}
+
+// scrambleSha256 computes the caching_sha2_password response a client is
+// expected to send for the cleartext password given scramble, so
+// cachingSha2PasswordAuth can verify it the same way
+// mysql_native_password verifies its SHA1 response.
+func scrambleSha256(password string, scramble []byte) []byte {
+	if password == "" {
+		return nil
+	}
+	h1 := sha256.Sum256([]byte(password))
+	h2 := sha256.Sum256(h1[:])
+	h3 := sha256.Sum256(append(h2[:], scramble...))
+	out := make([]byte, len(h1))
+	for i := range out {
+		out[i] = h1[i] ^ h3[i]
+	}
+	return out
+}
+
+// buildTLSConfig builds the tls.Config the frontend's handshake should
+// use from cfg's cert/key/CA settings. It returns a nil config (TLS
+// stays off) when no TLSCertFile is configured.
+func buildTLSConfig(cfg *models.Config) (*tls.Config, error) {
+	if cfg.TLSCertFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS cert/key: %v", err)
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if cfg.TLSCAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading TLS CA file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", cfg.TLSCAFile)
+		}
+		tlsConf.ClientCAs = pool
+		if cfg.TLSVerifyClientCert {
+			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return tlsConf, nil
+}
+
+// loadACLs builds the per-user ACL table from the schema config.
+func loadACLs(cfg *models.Config) map[string]*ACL {
+	acls := make(map[string]*ACL, len(cfg.Users))
+	for _, usr := range cfg.Users {
+		acls[usr.Name] = &ACL{
+			User:           usr.Name,
+			Password:       usr.Password,
+			AllowedSchemas: usr.AllowedSchemas,
+			ReadOnly:       usr.ReadOnly,
+		}
+	}
+	return acls
+}
+
+// checkWriteAllowed rejects sql with an access-denied error if the
+// connected user's ACL is read-only and sql looks like a write.
+func (m *MySqlHandler) checkWriteAllowed(sql string) error {
+	acl := m.acls[m.conn.User()]
+	if acl == nil || !acl.ReadOnly || !isWriteStatement(sql) {
+		return nil
+	}
+	return mysql.NewError(mysql.ER_ACCESS_DENIED_ERROR,
+		fmt.Sprintf("user %s is read-only", m.conn.User()))
+}
+
+// isWriteStatement is a best-effort check of whether sql mutates data,
+// used to enforce read-only ACLs before a statement is ever parsed.
+func isWriteStatement(sql string) bool {
+	trimmed := strings.ToLower(strings.TrimSpace(stripLeadingSQLComments(sql)))
+	for _, prefix := range []string{"insert", "update", "delete", "upsert", "replace", "create", "drop", "alter", "truncate"} {
+		if strings.HasPrefix(trimmed, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripLeadingSQLComments removes any `-- ...`, `# ...` and `/* ... */`
+// comments preceding the first statement keyword, so isWriteStatement
+// can't be bypassed by prefixing a write with a comment, eg
+// "/* select */ delete from users".
+func stripLeadingSQLComments(sql string) string {
+	for {
+		sql = strings.TrimLeft(sql, " \t\r\n")
+		switch {
+		case strings.HasPrefix(sql, "--"):
+			if i := strings.IndexAny(sql, "\r\n"); i >= 0 {
+				sql = sql[i+1:]
+			} else {
+				return ""
+			}
+		case strings.HasPrefix(sql, "#"):
+			if i := strings.IndexAny(sql, "\r\n"); i >= 0 {
+				sql = sql[i+1:]
+			} else {
+				return ""
+			}
+		case strings.HasPrefix(sql, "/*"):
+			if i := strings.Index(sql, "*/"); i >= 0 {
+				sql = sql[i+2:]
+			} else {
+				return ""
+			}
+		default:
+			return sql
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package mysqlfe
+
+import "testing"
+
+func TestACLAllowsSchema(t *testing.T) {
+	var nilACL *ACL
+	if !nilACL.allowsSchema("anything") {
+		t.Fatalf("nil ACL should allow every schema")
+	}
+
+	open := &ACL{User: "root"}
+	if !open.allowsSchema("anything") {
+		t.Fatalf("ACL with no AllowedSchemas should allow every schema")
+	}
+
+	restricted := &ACL{User: "reporting", AllowedSchemas: []string{"analytics"}}
+	if !restricted.allowsSchema("analytics") {
+		t.Fatalf("expected analytics to be allowed")
+	}
+	if restricted.allowsSchema("billing") {
+		t.Fatalf("expected billing to be denied")
+	}
+}
+
+func TestIsWriteStatement(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want bool
+	}{
+		{"select * from users", false},
+		{"INSERT INTO users (id) VALUES (1)", true},
+		{"  update users set name = 'bob'", true},
+		{"-- comment\ndelete from users", true},
+		{"# comment\ndelete from users", true},
+		{"/* comment */ delete from users", true},
+		{"/* select */ delete from users", true},
+		{"-- delete from users\nselect 1", false},
+	}
+	for _, c := range cases {
+		got := isWriteStatement(c.sql)
+		if got != c.want {
+			t.Errorf("isWriteStatement(%q) = %v, want %v", c.sql, got, c.want)
+		}
+	}
+}
+
+func TestScrambleSha256(t *testing.T) {
+	scramble := []byte("01234567890123456789")
+
+	if scrambleSha256("", scramble) != nil {
+		t.Fatalf("expected empty password to produce a nil response")
+	}
+
+	a := scrambleSha256("secret", scramble)
+	b := scrambleSha256("secret", scramble)
+	if len(a) == 0 || string(a) != string(b) {
+		t.Fatalf("expected scrambleSha256 to be deterministic for the same password/scramble")
+	}
+
+	c := scrambleSha256("other", scramble)
+	if string(a) == string(c) {
+		t.Fatalf("expected different passwords to produce different responses")
+	}
+}
@@ -1,8 +1,11 @@
 package mysqlfe
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"strings"
+	"time"
 
 	u "github.com/araddon/gou"
 	"github.com/kr/pretty"
@@ -37,16 +40,22 @@ var (
 // MySqlHandler shared across connections, used to create
 //   connection specific connections
 type MySqlHandlerShared struct {
-	svr *models.ServerCtx
+	svr           *models.ServerCtx
+	router        *Router                 // per-table shard rules for multi-node fan-out, if configured
+	tlsConfig     *tls.Config             // nil unless models.Config has TLSCertFile set
+	authProviders map[string]AuthProvider // auth plugin name -> provider, consulted during handshake
+	acls          map[string]*ACL         // username -> ACL, consulted by SchemaUse/chooseCommand
 }
 
 // MySql connection handler, a single connection session
 //  not threadsafe, not shared
 type MySqlHandler struct {
 	*MySqlHandlerShared
-	sess   expr.ContextReader // session info
-	conn   *proxy.Conn        // Connection to client, inbound mysql conn
-	schema *schema.Schema
+	sess    expr.ContextReader // session info
+	conn    *proxy.Conn        // Connection to client, inbound mysql conn
+	schema  *schema.Schema
+	stmts   map[uint32]*preparedStmt // prepared statements, keyed by statement id
+	stmtSeq uint32                   // next prepared statement id to hand out
 }
 
 func NewMySqlHandler(svr *models.ServerCtx) (models.ConnectionHandle, error) {
@@ -56,7 +65,38 @@ func NewMySqlHandler(svr *models.ServerCtx) (models.ConnectionHandle, error) {
 	return connHandler, err
 }
 
-func (m *MySqlHandlerShared) Init() error { return nil }
+func (m *MySqlHandlerShared) Init() error {
+	m.router = NewRouter(m.svr.Config.ShardRules)
+
+	tlsConfig, err := buildTLSConfig(m.svr.Config)
+	if err != nil {
+		return err
+	}
+	m.tlsConfig = tlsConfig
+
+	m.acls = loadACLs(m.svr.Config)
+	m.authProviders = map[string]AuthProvider{}
+	for _, name := range []string{"mysql_native_password", "caching_sha2_password"} {
+		var provider AuthProvider
+		if name == "mysql_native_password" {
+			provider = nativePasswordAuth{acls: m}
+		} else {
+			provider = cachingSha2PasswordAuth{acls: m}
+		}
+		m.authProviders[name] = provider
+	}
+	for _, provider := range m.svr.Config.AuthProviders {
+		m.authProviders[provider.Name()] = provider
+	}
+	return nil
+}
+
+// ACLFor implements aclProvider, letting the built-in AuthProvider
+// implementations resolve a connection's ACL without reaching back
+// through MySqlHandler.
+func (m *MySqlHandlerShared) ACLFor(user string) *ACL {
+	return m.acls[user]
+}
 
 // Open/Clone this handler as each handler is a per-client/conn copy of handler
 // - this occurs once when a new tcp-conn is established
@@ -65,10 +105,15 @@ func (m *MySqlHandler) Open(connI interface{}) models.Handler {
 
 	handler := MySqlHandler{MySqlHandlerShared: m.MySqlHandlerShared}
 	handler.sess = NewMySqlSessionVars()
+	handler.stmts = make(map[uint32]*preparedStmt)
 
 	if conn, ok := connI.(*proxy.Conn); ok {
 		//u.Debugf("Cloning Mysql handler %v", conn)
 		handler.conn = conn
+		if m.tlsConfig != nil {
+			conn.SetTLSConfig(m.tlsConfig)
+		}
+		conn.SetAuthProviders(m.authProviders)
 		return &handler
 	}
 	panic(fmt.Sprintf("not proxy.Conn? %T", connI))
@@ -90,6 +135,10 @@ func (m *MySqlHandler) Handle(writer models.ResultWriter, req *models.Request) e
 
 // Session level schema Use command of sql
 func (m *MySqlHandler) SchemaUse(db string) *schema.Schema {
+	if acl := m.acls[m.conn.User()]; !acl.allowsSchema(db) {
+		u.Warnf("user %s not allowed schema %s", m.conn.User(), db)
+		return nil
+	}
 	schema := m.svr.Schema(db)
 	if schema == nil {
 		u.Warnf("Could not find schema for db=%s", db)
@@ -111,8 +160,28 @@ func (m *MySqlHandler) chooseCommand(writer models.ResultWriter, req *models.Req
 		// mysql is going to deprecate it, so we don't support it
 		msg := fmt.Sprintf("command %d:%s is deprecated", cmd, mysql.CommandString(cmd))
 		return mysql.NewError(mysql.ER_WARN_DEPRECATED_SYNTAX, msg)
-	case mysql.COM_QUERY, mysql.COM_STMT_PREPARE:
-		return m.handleQuery(writer, string(req.Raw))
+	case mysql.COM_QUERY:
+		sql := string(req.Raw)
+		if err := m.checkWriteAllowed(sql); err != nil {
+			return err
+		}
+		return m.handleQuery(writer, sql)
+	case mysql.COM_STMT_PREPARE:
+		sql := string(req.Raw)
+		if err := m.checkWriteAllowed(sql); err != nil {
+			return err
+		}
+		return m.handleStmtPrepare(writer, sql)
+	case mysql.COM_STMT_EXECUTE:
+		return m.handleStmtExecute(writer, req.Raw)
+	case mysql.COM_STMT_SEND_LONG_DATA:
+		return m.handleStmtSendLongData(req.Raw)
+	case mysql.COM_STMT_RESET:
+		return m.handleStmtReset(req.Raw)
+	case mysql.COM_STMT_CLOSE:
+		return m.handleStmtClose(req.Raw)
+	case mysql.COM_PROCESS_KILL:
+		return m.handleProcessKill(req.Raw)
 	case mysql.COM_PING:
 		return m.writeOK(nil)
 	case mysql.COM_QUIT:
@@ -124,10 +193,6 @@ func (m *MySqlHandler) chooseCommand(writer models.ResultWriter, req *models.Req
 		} else {
 			return m.writeOK(nil)
 		}
-	// case mysql.COM_STMT_EXECUTE:
-	// case mysql.COM_STMT_CLOSE:
-	// case mysql.COM_STMT_SEND_LONG_DATA:
-	// case mysql.COM_STMT_RESET:
 	default:
 		msg := fmt.Sprintf("command %d:%s not yet supported", cmd, mysql.CommandString(cmd))
 		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, msg)
@@ -136,8 +201,128 @@ func (m *MySqlHandler) chooseCommand(writer models.ResultWriter, req *models.Req
 	return nil
 }
 
+// planQuery parses sql against the schema/session currently in use on this
+// connection and builds the job that would run it. Shared by handleQuery
+// and the COM_STMT_PREPARE path, which only needs the parsed statement and
+// never runs the job itself.
+func (m *MySqlHandler) planQuery(queryCtx context.Context, sql string) (*plan.Job, error) {
+	return m.planQueryOnNode(queryCtx, sql, "")
+}
+
+// planQueryOnNode is planQuery plus an optional target node, set by the
+// Router when a statement has been routed to a specific shard. An empty
+// node leaves the choice of backend up to the schema as before.
+func (m *MySqlHandler) planQueryOnNode(queryCtx context.Context, sql, node string) (*plan.Job, error) {
+	if m.schema == nil {
+		u.Warnf("missing schema?  ")
+		return nil, fmt.Errorf("no schema in use")
+	}
+
+	// Ensure it parses, right now we can't handle multiple statement (ie with semi-colons separating)
+	// sql = strings.TrimRight(sql, ";")
+	ctx := plan.NewContext(sql)
+	ctx.Context = queryCtx
+	ctx.DisableRecover = m.svr.Config.SupressRecover
+	ctx.Session = m.sess
+	ctx.Schema = m.schema
+	ctx.ShardNode = node
+	return BuildMySqlJob(m.svr, ctx)
+}
+
+// buildRoutedJobs plans sql against however many nodes the Router decides
+// it must run on. routeSQL is what the Router matches its shard-value
+// predicate against: for a plain COM_QUERY it's the same as sql, but for
+// a prepared statement sql still carries unsubstituted `?` placeholders,
+// so callers pass a bind-value-substituted routeSQL instead (see
+// renderSQLForRouting) - otherwise a parameterized shard key could never
+// match. The common case is exactly one job: either the table isn't
+// sharded, or the statement's predicate narrowed it to a single node. A
+// fan-out rule with no narrowing predicate produces one job per target
+// node, for runJobs to run behind a mergeTask.
+func (m *MySqlHandler) buildRoutedJobs(queryCtx context.Context, sql, routeSQL string) ([]*plan.Job, []string, error) {
+	table := tableFromSQL(routeSQL)
+	if table == "" || m.router == nil {
+		job, err := m.planQuery(queryCtx, sql)
+		if err != nil || job == nil {
+			return nil, nil, err
+		}
+		return []*plan.Job{job}, nil, nil
+	}
+
+	route, err := m.router.Route(table, routeSQL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(route.Nodes) == 0 {
+		job, err := m.planQuery(queryCtx, sql)
+		if err != nil || job == nil {
+			return nil, nil, err
+		}
+		return []*plan.Job{job}, nil, nil
+	}
+
+	jobs := make([]*plan.Job, 0, len(route.Nodes))
+	for _, node := range route.Nodes {
+		job, err := m.planQueryOnNode(queryCtx, sql, node)
+		if err != nil {
+			for _, built := range jobs {
+				built.Close()
+			}
+			return nil, nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, route.Nodes, nil
+}
+
+// startQuery derives a cancelable context for a single query from the
+// server's shutdown context, and registers its cancel func in the
+// session registry under this connection's id so another session's
+// `KILL QUERY <id>` (COM_PROCESS_KILL) can unwind it.
+func (m *MySqlHandler) startQuery() (context.Context, func()) {
+	connID := m.conn.ConnectionId()
+	queryCtx, cancel := context.WithCancel(m.svr.Ctx)
+	sessions.register(connID, cancel)
+	return queryCtx, func() {
+		sessions.unregister(connID)
+		cancel()
+	}
+}
+
+// handleProcessKill implements COM_PROCESS_KILL / `KILL QUERY <id>`: it
+// cancels the target connection's in-flight query context, which unwinds
+// its exec.Task DAG, without touching the connection itself.
+func (m *MySqlHandler) handleProcessKill(raw []byte) error {
+	connID, err := mysql.ParseConnectionId(raw)
+	if err != nil {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("bad COM_PROCESS_KILL: %v", err))
+	}
+	sessions.kill(connID)
+	return m.writeOK(nil)
+}
+
 func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err error) {
 
+	start := time.Now()
+	var rows int
+	var backends []string
+
+	defer func() {
+		schemaName := ""
+		if m.schema != nil {
+			schemaName = m.schema.Name
+		}
+		reportQuery(QueryEvent{
+			Sql:        sql,
+			ClientAddr: m.conn.RemoteAddr().String(),
+			Schema:     schemaName,
+			Backends:   backends,
+			Rows:       rows,
+			Elapsed:    time.Since(start),
+			Err:        err,
+		}, m.svr.Config.SlowLogThresholdMs)
+	}()
+
 	//u.Debugf("handleQuery: %v", sql)
 	if !m.svr.Config.SupressRecover {
 		//u.Debugf("running recovery? ")
@@ -150,19 +335,11 @@ func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err
 		}()
 	}
 
-	if m.schema == nil {
-		u.Warnf("missing schema?  ")
-		return fmt.Errorf("no schema in use")
-	}
-
-	// Ensure it parses, right now we can't handle multiple statement (ie with semi-colons separating)
-	// sql = strings.TrimRight(sql, ";")
-	ctx := plan.NewContext(sql)
-	ctx.DisableRecover = m.svr.Config.SupressRecover
-	ctx.Session = m.sess
-	ctx.Schema = m.schema
-	job, err := BuildMySqlJob(m.svr, ctx)
+	queryCtx, done := m.startQuery()
+	defer done()
 
+	jobs, routedBackends, err := m.buildRoutedJobs(queryCtx, sql, sql)
+	backends = routedBackends
 	if err != nil {
 		//u.Debugf("error? %v", err)
 		sql = strings.ToLower(sql)
@@ -170,12 +347,13 @@ func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err
 		case strings.HasPrefix(sql, "set "):
 			// set autocommit
 			// SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ
+			err = nil
 			return m.conn.WriteOK(nil)
 		}
-		u.Debugf("error on parse sql statement: %v", err)
+		u.Debugf("error on parse/route sql statement: %v", err)
 		return err
 	}
-	if job == nil {
+	if len(jobs) == 0 {
 		// we are done, already wrote results
 		return nil
 	}
@@ -183,6 +361,52 @@ func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err
 	//u.Infof("job.Ctx %p   Session %p", job.Ctx, job.Ctx.Session)
 	//job.Ctx.Session = m.sess
 
+	if len(jobs) == 1 {
+		rows, err = m.runJob(writer, jobs[0])
+		return err
+	}
+	rows, err = m.runJobs(writer, jobs)
+	return err
+}
+
+// runJobs runs a statement that the Router fanned out to more than one
+// node: it builds a single terminal resultWriter from the first job's
+// statement type, then drives every sub-job against it via a mergeTask.
+// It returns the rows written by that shared resultWriter, if it reports one.
+//
+// A broadcast SELECT is refused outright rather than run: mergeTask has
+// no way to union rows from independent sub-jobs into one result set, so
+// running it would silently hand the client whatever the first node
+// happened to return and call it complete. A broadcast write doesn't
+// have this problem - each node's write is applied for real, and only
+// the acknowledgement is taken from the first node - so those still run
+// via mergeTask.
+func (m *MySqlHandler) runJobs(writer models.ResultWriter, jobs []*plan.Job) (int, error) {
+
+	primary := jobs[0]
+	var resultWriter exec.Task
+	switch stmt := primary.Ctx.Stmt.(type) {
+	case *rel.SqlSelect:
+		return 0, fmt.Errorf("query routed to %d nodes with no narrowing predicate: "+
+			"broadcast SELECT result merging is not supported, add a predicate on the shard column", len(jobs))
+	case *rel.SqlInsert, *rel.SqlUpsert, *rel.SqlUpdate, *rel.SqlDelete:
+		resultWriter = NewMySqlExecResultWriter(writer, primary.Ctx)
+	default:
+		u.Warnf("routed statement type %T not supported for multi-node fan-out", stmt)
+		return 0, fmt.Errorf("statement type %T not supported for multi-node fan-out", stmt)
+	}
+
+	err := newMergeTask(primary.Ctx, jobs, resultWriter).Run()
+	return rowsWritten(resultWriter), err
+}
+
+// runJob dispatches a planned job to the result-writer matching its
+// statement type, then finalizes and runs it. Shared by handleQuery and
+// handleStmtExecute, which both arrive at a *plan.Job a different way. It
+// returns the rows the resultWriter reports having written, for the
+// slow-query log and QueryObservers.
+func (m *MySqlHandler) runJob(writer models.ResultWriter, job *plan.Job) (int, error) {
+
 	var resultWriter exec.Task
 	switch stmt := job.Ctx.Stmt.(type) {
 	case *rel.SqlSelect:
@@ -192,20 +416,20 @@ func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err
 	case *rel.SqlInsert, *rel.SqlUpsert, *rel.SqlUpdate, *rel.SqlDelete:
 		resultWriter = NewMySqlExecResultWriter(writer, job.Ctx)
 	case *rel.SqlCommand:
-		return m.conn.WriteOK(nil)
+		return 0, m.conn.WriteOK(nil)
 	default:
 		u.Warnf("sql not supported?  %v  %T", stmt, stmt)
-		return fmt.Errorf("statement type %T not supported", stmt)
+		return 0, fmt.Errorf("statement type %T not supported", stmt)
 	}
 
 	// job.Finalize() will:
 	//  - insert any network/distributed tasks to other worker nodes
 	//  - wait for those nodes to be ready to run
 	//  - append the result writer after those tasks
-	err = job.Finalize(resultWriter)
+	err := job.Finalize(resultWriter)
 	if err != nil {
 		u.Errorf("error on finalize %v", err)
-		return err
+		return 0, err
 	}
 	//u.Infof("about to run")
 	err = job.Run()
@@ -214,7 +438,16 @@ func (m *MySqlHandler) handleQuery(writer models.ResultWriter, sql string) (err
 		u.Errorf("error on Query.Run(): %v", err)
 	}
 	job.Close()
-	return err
+	return rowsWritten(resultWriter), err
+}
+
+// rowsWritten reports how many rows a resultWriter produced, for result
+// writer types (NewMySqlResultWriter et al) that track it.
+func rowsWritten(t exec.Task) int {
+	if rc, ok := t.(interface{ RowsWritten() int }); ok {
+		return rc.RowsWritten()
+	}
+	return 0
 }
 
 func (m *MySqlHandler) writeOK(r *mysql.Result) error {
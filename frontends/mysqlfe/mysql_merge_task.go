@@ -0,0 +1,92 @@
+package mysqlfe
+
+import (
+	u "github.com/araddon/gou"
+
+	"github.com/araddon/qlbridge/exec"
+	"github.com/araddon/qlbridge/plan"
+)
+
+// mergeTask is the counterpart to Router: once a write statement has
+// been routed to more than one node (an explicit fan-out rule, or a
+// broadcast), it replaces the single-job path in runJob with one sub-job
+// per target node. runJobs refuses to build a mergeTask for a broadcast
+// SELECT at all, since there's no way to union rows from independent
+// sub-jobs into one result set here - see runJobs' doc comment.
+//
+// Only the first sub-job is finalized against the real, client-facing
+// resultWriter - resultWriter types like NewMySqlExecResultWriter write
+// a header/row/EOF packet sequence to the wire exactly once per Run(),
+// so finalizing every sub-job against the same instance would make each
+// shard write its own copy of that sequence, corrupting the MySQL
+// protocol stream for the client. The remaining sub-jobs are finalized
+// against a discardTask instead: they still run to completion, so a
+// broadcast UPDATE/DELETE is applied on every node, but only the first
+// node's acknowledgement reaches the client.
+type mergeTask struct {
+	*exec.TaskBase
+	jobs         []*plan.Job
+	resultWriter exec.Task
+}
+
+// newMergeTask wraps the per-node sub-jobs produced by the router so they
+// run as a single exec.Task in the parent job's DAG.
+func newMergeTask(ctx *plan.Context, jobs []*plan.Job, resultWriter exec.Task) *mergeTask {
+	return &mergeTask{
+		TaskBase:     exec.NewTaskBase(ctx, "MergeTask"),
+		jobs:         jobs,
+		resultWriter: resultWriter,
+	}
+}
+
+// Run drives each per-node sub-job to completion, the first against the
+// real resultWriter and the rest against a discardTask (see mergeTask's
+// doc comment). It keeps running the remaining shards after an error so
+// a mid-broadcast failure on one node doesn't leave the others
+// half-applied, and returns the first error seen.
+func (t *mergeTask) Run() error {
+	defer t.Close()
+
+	var firstErr error
+	for i, job := range t.jobs {
+		rw := t.resultWriter
+		if i > 0 {
+			rw = newDiscardTask(job.Ctx)
+		}
+		if err := job.Finalize(rw); err != nil {
+			u.Errorf("error on finalize of routed sub-job: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if err := job.Run(); err != nil {
+			u.Errorf("error running routed sub-job: %v", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		job.Close()
+	}
+	return firstErr
+}
+
+// discardTask is a terminal exec.Task that accepts rows without writing
+// them anywhere, used by mergeTask to run a broadcast job to completion
+// (so its side effects, if any, still take place) without it competing
+// with the primary sub-job to write the client-facing result.
+type discardTask struct {
+	*exec.TaskBase
+}
+
+func newDiscardTask(ctx *plan.Context) *discardTask {
+	return &discardTask{TaskBase: exec.NewTaskBase(ctx, "DiscardTask")}
+}
+
+func (t *discardTask) Run() error {
+	defer t.Close()
+	for range t.MessageIn() {
+		// drain and drop
+	}
+	return nil
+}
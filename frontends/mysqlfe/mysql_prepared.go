@@ -0,0 +1,280 @@
+package mysqlfe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	u "github.com/araddon/gou"
+
+	"github.com/araddon/qlbridge/rel"
+
+	"github.com/dataux/dataux/models"
+	"github.com/dataux/dataux/vendored/mixer/mysql"
+)
+
+// preparedStmt is the per-connection state for a statement between its
+// COM_STMT_PREPARE and matching COM_STMT_CLOSE (COM_STMT_RESET only
+// clears its long data). It does
+// not cache the parsed rel.SqlStatement: a prepared statement can be
+// routed to a different node (or a different set of nodes, for a
+// broadcast) on every EXECUTE depending on its bound values, so sql is
+// re-planned per EXECUTE via buildRoutedJobs rather than reusing a
+// single parse from PREPARE time.
+type preparedStmt struct {
+	id         uint32
+	sql        string
+	paramCount int
+	colCount   int
+	longData   map[int][]byte // param index -> accumulated COM_STMT_SEND_LONG_DATA bytes
+}
+
+// handleStmtPrepare parses sql, registers a preparedStmt keyed by a newly
+// allocated statement id, and writes the PREPARE_OK + param/column
+// definition packets the client needs before it can EXECUTE.
+func (m *MySqlHandler) handleStmtPrepare(writer models.ResultWriter, sql string) (err error) {
+
+	if !m.svr.Config.SupressRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				u.Errorf("recover? %v", e)
+				err = fmt.Errorf("handle stmt prepare %s error %v", sql, e)
+			}
+		}()
+	}
+
+	job, err := m.planQuery(context.Background(), sql)
+	if err != nil {
+		u.Debugf("error on parse of prepared sql statement: %v", err)
+		return err
+	}
+	if job == nil {
+		return fmt.Errorf("prepared statement %q produced no job", sql)
+	}
+	defer job.Close()
+
+	stmt := job.Ctx.Stmt
+	paramCount := countPlaceholders(sql)
+	colCount := 0
+	if sel, ok := stmt.(*rel.SqlSelect); ok {
+		colCount = len(sel.Columns)
+	}
+
+	m.stmtSeq++
+	ps := &preparedStmt{
+		id:         m.stmtSeq,
+		sql:        sql,
+		paramCount: paramCount,
+		colCount:   colCount,
+		longData:   make(map[int][]byte),
+	}
+	m.stmts[ps.id] = ps
+
+	return m.conn.WritePrepareOK(ps.id, uint16(ps.colCount), uint16(ps.paramCount))
+}
+
+// countPlaceholders counts the `?` placeholders a prepared sql statement
+// expects values for, skipping any '...' or "..." that appear inside
+// string literals so a literal question mark (eg "what?") isn't counted
+// as a bind parameter.
+func countPlaceholders(sql string) int {
+	count := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++ // skip escaped char, including an escaped quote
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			count++
+		}
+	}
+	return count
+}
+
+// renderSQLForRouting substitutes args, in order, for the unbound `?`
+// placeholders in a prepared statement's sql, producing literal text the
+// Router's shard-value regex can match a parameterized shard key
+// against. It's used only to decide which node(s) the statement routes
+// to - the original sql (placeholders intact) is still what gets
+// planned and bound via job.Ctx.Params.
+func renderSQLForRouting(sql string, args []interface{}) string {
+	var out strings.Builder
+	argIdx := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		c := sql[i]
+		switch {
+		case quote != 0:
+			out.WriteByte(c)
+			if c == '\\' && i+1 < len(sql) {
+				i++
+				out.WriteByte(sql[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			out.WriteByte(c)
+		case c == '?' && argIdx < len(args):
+			out.WriteString(routingLiteral(args[argIdx]))
+			argIdx++
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return out.String()
+}
+
+// routingLiteral renders a bound param value as SQL text suitable for
+// shardValuePattern to match: a quoted string for anything textual, a
+// bare token otherwise.
+func routingLiteral(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return "'" + strings.Replace(string(t), "'", "''", -1) + "'"
+	case string:
+		return "'" + strings.Replace(t, "'", "''", -1) + "'"
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+// handleStmtExecute decodes the binary COM_STMT_EXECUTE packet (statement
+// id + NULL-bitmap + binary-encoded param values), binds them into a
+// plan.Context via makeBindVars, runs the job and streams results back in
+// the binary protocol via NewMySqlResultWriter.
+func (m *MySqlHandler) handleStmtExecute(writer models.ResultWriter, raw []byte) (err error) {
+
+	start := time.Now()
+	var rows int
+	var backends []string
+	var sql string
+
+	defer func() {
+		schemaName := ""
+		if m.schema != nil {
+			schemaName = m.schema.Name
+		}
+		reportQuery(QueryEvent{
+			Sql:        sql,
+			ClientAddr: m.conn.RemoteAddr().String(),
+			Schema:     schemaName,
+			Backends:   backends,
+			Rows:       rows,
+			Elapsed:    time.Since(start),
+			Err:        err,
+		}, m.svr.Config.SlowLogThresholdMs)
+	}()
+
+	if !m.svr.Config.SupressRecover {
+		defer func() {
+			if e := recover(); e != nil {
+				u.Errorf("recover? %v", e)
+				err = fmt.Errorf("handle stmt execute %s error %v", sql, e)
+			}
+		}()
+	}
+
+	stmtID, args, err := mysql.ParseStmtExecute(raw)
+	if err != nil {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("bad COM_STMT_EXECUTE: %v", err))
+	}
+
+	ps, ok := m.stmts[stmtID]
+	if !ok {
+		return mysql.NewError(mysql.ER_UNKNOWN_STMT_HANDLER, fmt.Sprintf("unknown prepared statement id %d", stmtID))
+	}
+	sql = ps.sql
+
+	for idx, buf := range ps.longData {
+		if idx < len(args) {
+			args[idx] = buf
+		}
+	}
+
+	queryCtx, done := m.startQuery()
+	defer done()
+
+	routeSQL := renderSQLForRouting(ps.sql, args)
+	jobs, routedBackends, err := m.buildRoutedJobs(queryCtx, ps.sql, routeSQL)
+	backends = routedBackends
+	if err != nil {
+		u.Debugf("error re-planning prepared statement %d: %v", stmtID, err)
+		return err
+	}
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	bindVars := makeBindVars(args)
+	for _, job := range jobs {
+		job.Ctx.Session = m.sess
+		job.Ctx.Params = bindVars
+	}
+
+	if len(jobs) == 1 {
+		rows, err = m.runJob(writer, jobs[0])
+		return err
+	}
+	rows, err = m.runJobs(writer, jobs)
+	return err
+}
+
+// handleStmtSendLongData appends a chunk of binary param data onto the
+// matching prepared statement, for params too large to fit in one
+// COM_STMT_EXECUTE packet (BLOB/TEXT columns).
+func (m *MySqlHandler) handleStmtSendLongData(raw []byte) error {
+
+	stmtID, paramID, data, err := mysql.ParseStmtSendLongData(raw)
+	if err != nil {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("bad COM_STMT_SEND_LONG_DATA: %v", err))
+	}
+
+	ps, ok := m.stmts[stmtID]
+	if !ok {
+		// Per protocol, errors here are not returned to the client.
+		u.Warnf("COM_STMT_SEND_LONG_DATA for unknown statement id %d", stmtID)
+		return nil
+	}
+	ps.longData[paramID] = append(ps.longData[paramID], data...)
+	return nil
+}
+
+// handleStmtReset clears any accumulated long-data and prepares the
+// statement to be EXECUTE'd again from scratch.
+func (m *MySqlHandler) handleStmtReset(raw []byte) error {
+
+	stmtID, err := mysql.ParseStmtID(raw)
+	if err != nil {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("bad COM_STMT_RESET: %v", err))
+	}
+
+	ps, ok := m.stmts[stmtID]
+	if !ok {
+		return mysql.NewError(mysql.ER_UNKNOWN_STMT_HANDLER, fmt.Sprintf("unknown prepared statement id %d", stmtID))
+	}
+	ps.longData = make(map[int][]byte)
+	return m.writeOK(nil)
+}
+
+// handleStmtClose frees the prepared statement's state. Per protocol, no
+// response packet is sent back to the client.
+func (m *MySqlHandler) handleStmtClose(raw []byte) error {
+
+	stmtID, err := mysql.ParseStmtID(raw)
+	if err != nil {
+		return nil
+	}
+	delete(m.stmts, stmtID)
+	return nil
+}
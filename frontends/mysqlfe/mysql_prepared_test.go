@@ -0,0 +1,43 @@
+package mysqlfe
+
+import "testing"
+
+func TestRenderSQLForRouting(t *testing.T) {
+	cases := []struct {
+		sql  string
+		args []interface{}
+		want string
+	}{
+		{"select * from users where user_id = ?", []interface{}{int64(42)}, "select * from users where user_id = 42"},
+		{"select * from users where name = ?", []interface{}{"o'brien"}, "select * from users where name = 'o''brien'"},
+		{"select * from users where name = ? and id = ?", []interface{}{[]byte("bob"), int64(7)}, "select * from users where name = 'bob' and id = 7"},
+		{"select * from users where note = 'literal ?' and id = ?", []interface{}{int64(1)}, "select * from users where note = 'literal ?' and id = 1"},
+	}
+	for _, c := range cases {
+		got := renderSQLForRouting(c.sql, c.args)
+		if got != c.want {
+			t.Errorf("renderSQLForRouting(%q, %v) = %q, want %q", c.sql, c.args, got, c.want)
+		}
+	}
+}
+
+func TestCountPlaceholders(t *testing.T) {
+	cases := []struct {
+		sql  string
+		want int
+	}{
+		{"select * from users where id = ?", 1},
+		{"insert into users (name, age) values (?, ?)", 2},
+		{"select * from users where name = 'what?'", 0},
+		{`select * from users where name = "what?"`, 0},
+		{`select * from users where note = 'it''s ?' and id = ?`, 1},
+		{`select * from users where note = 'escaped \' ?'`, 0},
+		{"select 1", 0},
+	}
+	for _, c := range cases {
+		got := countPlaceholders(c.sql)
+		if got != c.want {
+			t.Errorf("countPlaceholders(%q) = %d, want %d", c.sql, got, c.want)
+		}
+	}
+}
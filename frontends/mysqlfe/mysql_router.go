@@ -0,0 +1,202 @@
+package mysqlfe
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strconv"
+)
+
+// ShardAlgorithm selects how a shard key value maps to a node.
+type ShardAlgorithm string
+
+const (
+	ShardHash  ShardAlgorithm = "hash"
+	ShardRange ShardAlgorithm = "range"
+	ShardList  ShardAlgorithm = "list"
+)
+
+// ShardValueRange is one bound of a ShardRule using the "range" algorithm.
+type ShardValueRange struct {
+	Max  int64 // upper bound, exclusive; the last range should use MaxInt64
+	Node string
+}
+
+// ShardRule is the per-table sharding config declared in the schema:
+// which column to shard on, how to map its value to a node, and what to
+// do when a statement carries no predicate on that column.
+type ShardRule struct {
+	Table           string
+	Column          string
+	Algorithm       ShardAlgorithm
+	Nodes           []string          // node addresses, for "hash"
+	List            map[string]string // value -> node, for "list"
+	Ranges          []ShardValueRange // ascending, for "range"
+	DefaultNode     string            // used when a value falls outside List/Ranges
+	RequireCriteria bool              // refuse to broadcast when no predicate narrows the node
+}
+
+// ErrNoCriteria is returned when a statement targets a table whose
+// ShardRule has RequireCriteria set, but carries no predicate on the
+// shard column to route by.
+var ErrNoCriteria = errors.New("no shard criteria found for statement")
+
+// RouteResult is the outcome of routing a single statement: either a
+// single target node (the common case), or, when Broadcast is true, every
+// node the table is sharded across.
+type RouteResult struct {
+	Nodes     []string
+	Broadcast bool
+}
+
+// Router picks the backend node(s) a statement should run against, based
+// on schema-level ShardRules declared per table.
+type Router struct {
+	Rules map[string]*ShardRule // keyed by table name
+}
+
+// NewRouter builds a Router from the schema's per-table shard rules.
+// A nil or empty rules map is valid: every table is then treated as
+// unsharded and Route always returns a single, empty-node result that
+// callers should interpret as "run it locally, no routing needed".
+func NewRouter(rules map[string]*ShardRule) *Router {
+	return &Router{Rules: rules}
+}
+
+// Route decides which node(s) sql (operating on table) should run
+// against. When no shard value can be extracted - no predicate on the
+// column at all, or one this simple extractor can't see through (a join
+// predicate, say) - it returns ErrNoCriteria if the rule's
+// RequireCriteria is set, and otherwise broadcasts to every node. This
+// applies equally to reads and writes: a DELETE/UPDATE with no
+// shardable WHERE is expected to fan out to every shard, exactly like a
+// broadcast SELECT, unless the table's rule opts out via
+// RequireCriteria.
+func (r *Router) Route(table, sql string) (RouteResult, error) {
+	rule, ok := r.Rules[table]
+	if !ok {
+		return RouteResult{}, nil
+	}
+
+	value, ok := shardValue(sql, rule.Column)
+	if !ok {
+		if rule.RequireCriteria {
+			return RouteResult{}, ErrNoCriteria
+		}
+		return RouteResult{Nodes: rule.allNodes(), Broadcast: true}, nil
+	}
+
+	node, err := rule.nodeFor(value)
+	if err != nil {
+		return RouteResult{}, err
+	}
+	return RouteResult{Nodes: []string{node}}, nil
+}
+
+// shardValue pulls a `column = 'value'` / `column = 123` equality
+// predicate for column out of a raw SQL statement, tolerating an
+// optional table/alias qualifier (`u.user_id = 5`). The value must be a
+// quoted string or a bare number: a bare identifier on the right-hand
+// side (`orders.user_id = customers.id`) is a join predicate, not a
+// literal, and must not be mistaken for one, so it's deliberately not
+// matched here - that falls through to the no-criteria path like any
+// other predicate this simple extractor can't see through.
+func shardValue(sql, column string) (string, bool) {
+	m := shardValuePattern(column).FindStringSubmatch(sql)
+	if m == nil {
+		return "", false
+	}
+	if m[1] != "" {
+		return m[1], true // quoted string literal
+	}
+	return m[2], true // bare numeric literal
+}
+
+func shardValuePattern(column string) *regexp.Regexp {
+	col := regexp.QuoteMeta(column)
+	return regexp.MustCompile(`(?i)(?:\w+\.)?` + col + `\s*=\s*(?:'([^']*)'|(-?[0-9]+(?:\.[0-9]+)?))`)
+}
+
+// tableFromSQL extracts the first table name following FROM/INTO/UPDATE,
+// the table the router needs to look up a ShardRule for.
+func tableFromSQL(sql string) string {
+	m := tableNamePattern.FindStringSubmatch(sql)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
+
+var tableNamePattern = regexp.MustCompile(`(?i)(?:FROM|INTO|UPDATE)\s+` + "`?" + `([a-zA-Z0-9_\.]+)` + "`?")
+
+// allNodes returns every node this rule might route to, used for
+// broadcast fan-out.
+func (rule *ShardRule) allNodes() []string {
+	switch rule.Algorithm {
+	case ShardList:
+		nodes := make([]string, 0, len(rule.List))
+		seen := make(map[string]bool, len(rule.List))
+		for _, n := range rule.List {
+			if !seen[n] {
+				seen[n] = true
+				nodes = append(nodes, n)
+			}
+		}
+		if rule.DefaultNode != "" && !seen[rule.DefaultNode] {
+			nodes = append(nodes, rule.DefaultNode)
+		}
+		return nodes
+	case ShardRange:
+		nodes := make([]string, 0, len(rule.Ranges)+1)
+		seen := make(map[string]bool, len(rule.Ranges)+1)
+		for _, rg := range rule.Ranges {
+			if !seen[rg.Node] {
+				seen[rg.Node] = true
+				nodes = append(nodes, rg.Node)
+			}
+		}
+		if rule.DefaultNode != "" && !seen[rule.DefaultNode] {
+			nodes = append(nodes, rule.DefaultNode)
+		}
+		return nodes
+	default:
+		return rule.Nodes
+	}
+}
+
+// nodeFor maps a shard key value to a single node using the rule's
+// configured algorithm.
+func (rule *ShardRule) nodeFor(val string) (string, error) {
+	switch rule.Algorithm {
+	case ShardList:
+		if node, ok := rule.List[val]; ok {
+			return node, nil
+		}
+		if rule.DefaultNode != "" {
+			return rule.DefaultNode, nil
+		}
+		return "", fmt.Errorf("no shard list entry for value %q", val)
+	case ShardRange:
+		n, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("range shard key %q is not numeric: %v", val, err)
+		}
+		for _, rg := range rule.Ranges {
+			if n < rg.Max {
+				return rg.Node, nil
+			}
+		}
+		if rule.DefaultNode != "" {
+			return rule.DefaultNode, nil
+		}
+		return "", fmt.Errorf("value %d out of configured shard ranges", n)
+	default: // ShardHash
+		if len(rule.Nodes) == 0 {
+			return "", errors.New("hash shard rule has no nodes configured")
+		}
+		h := fnv.New32a()
+		h.Write([]byte(val))
+		return rule.Nodes[int(h.Sum32())%len(rule.Nodes)], nil
+	}
+}
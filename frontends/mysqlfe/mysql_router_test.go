@@ -0,0 +1,151 @@
+package mysqlfe
+
+import "testing"
+
+func TestRouteEqualityPredicate(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"users": {
+			Table:     "users",
+			Column:    "user_id",
+			Algorithm: ShardList,
+			List:      map[string]string{"42": "node-a"},
+		},
+	})
+
+	route, err := r.Route("users", "select * from users where user_id = '42'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if route.Broadcast || len(route.Nodes) != 1 || route.Nodes[0] != "node-a" {
+		t.Fatalf("expected single-node route to node-a, got %+v", route)
+	}
+}
+
+func TestRouteAliasQualifiedPredicate(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"users": {
+			Table:     "users",
+			Column:    "user_id",
+			Algorithm: ShardList,
+			List:      map[string]string{"42": "node-a"},
+		},
+	})
+
+	route, err := r.Route("users", "select * from users u where u.user_id = 42")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(route.Nodes) != 1 || route.Nodes[0] != "node-a" {
+		t.Fatalf("expected alias-qualified predicate to route to node-a, got %+v", route)
+	}
+}
+
+func TestRouteJoinPredicateIsNotMistakenForLiteral(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"orders": {
+			Table:     "orders",
+			Column:    "user_id",
+			Algorithm: ShardHash,
+			Nodes:     []string{"node-a", "node-b"},
+		},
+	})
+
+	// orders.user_id = customers.id is a join predicate, not a literal -
+	// it must fall through to broadcast, not silently route using
+	// "customers.id" as if it were a shard value.
+	route, err := r.Route("orders", "select * from orders join customers on orders.user_id = customers.id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !route.Broadcast {
+		t.Fatalf("expected join predicate to broadcast, got %+v", route)
+	}
+}
+
+func TestRouteNoCriteriaOnWriteBroadcasts(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"orders": {
+			Table:     "orders",
+			Column:    "user_id",
+			Algorithm: ShardHash,
+			Nodes:     []string{"node-a", "node-b"},
+		},
+	})
+
+	// A write with no shardable WHERE fans out to every shard, same as a
+	// broadcast SELECT, unless the rule's RequireCriteria says otherwise.
+	route, err := r.Route("orders", "update orders set status = 'shipped'")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !route.Broadcast || len(route.Nodes) != 2 {
+		t.Fatalf("expected broadcast write to both nodes, got %+v", route)
+	}
+}
+
+func TestRouteNoCriteriaOnReadBroadcasts(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"orders": {
+			Table:     "orders",
+			Column:    "user_id",
+			Algorithm: ShardHash,
+			Nodes:     []string{"node-a", "node-b"},
+		},
+	})
+
+	route, err := r.Route("orders", "select * from orders")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !route.Broadcast || len(route.Nodes) != 2 {
+		t.Fatalf("expected broadcast to both nodes, got %+v", route)
+	}
+}
+
+func TestRouteRequireCriteriaRejectsWriteWithNoPredicate(t *testing.T) {
+	r := NewRouter(map[string]*ShardRule{
+		"orders": {
+			Table:           "orders",
+			Column:          "user_id",
+			Algorithm:       ShardHash,
+			Nodes:           []string{"node-a", "node-b"},
+			RequireCriteria: true,
+		},
+	})
+
+	_, err := r.Route("orders", "update orders set status = 'shipped'")
+	if err != ErrNoCriteria {
+		t.Fatalf("expected ErrNoCriteria when RequireCriteria is set, got %v", err)
+	}
+}
+
+func TestShardRuleNodeForRange(t *testing.T) {
+	rule := &ShardRule{
+		Algorithm: ShardRange,
+		Ranges: []ShardValueRange{
+			{Max: 100, Node: "node-a"},
+			{Max: 1 << 62, Node: "node-b"},
+		},
+	}
+
+	node, err := rule.nodeFor("50")
+	if err != nil || node != "node-a" {
+		t.Fatalf("expected node-a for 50, got %q err=%v", node, err)
+	}
+	node, err = rule.nodeFor("500")
+	if err != nil || node != "node-b" {
+		t.Fatalf("expected node-b for 500, got %q err=%v", node, err)
+	}
+}
+
+func TestShardRuleAllNodesDedupesListNodes(t *testing.T) {
+	rule := &ShardRule{
+		Algorithm: ShardList,
+		List:      map[string]string{"a": "node-a", "b": "node-a", "c": "node-b"},
+	}
+
+	nodes := rule.allNodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 distinct nodes, got %v", nodes)
+	}
+}
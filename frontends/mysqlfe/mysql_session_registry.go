@@ -0,0 +1,45 @@
+package mysqlfe
+
+import "sync"
+
+// sessions is the process-wide registry of in-flight query cancel funcs,
+// keyed by connection id. It lets COM_PROCESS_KILL on one connection
+// (`KILL QUERY <id>` from a client) reach across and cancel the
+// plan.Context belonging to another, unwinding its exec.Task DAG.
+var sessions = newSessionRegistry()
+
+type sessionRegistry struct {
+	mu      sync.Mutex
+	cancels map[uint32]func()
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{cancels: make(map[uint32]func())}
+}
+
+// register records the cancel func for connID's currently running query.
+func (r *sessionRegistry) register(connID uint32, cancel func()) {
+	r.mu.Lock()
+	r.cancels[connID] = cancel
+	r.mu.Unlock()
+}
+
+// unregister removes connID's entry once its query has finished, whether
+// it ran to completion or was canceled.
+func (r *sessionRegistry) unregister(connID uint32) {
+	r.mu.Lock()
+	delete(r.cancels, connID)
+	r.mu.Unlock()
+}
+
+// kill cancels connID's in-flight query, if it has one. Returns false if
+// connID has no query currently running.
+func (r *sessionRegistry) kill(connID uint32) bool {
+	r.mu.Lock()
+	cancel, ok := r.cancels[connID]
+	r.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return ok
+}
@@ -0,0 +1,33 @@
+package mysqlfe
+
+import "testing"
+
+func TestSessionRegistryKillCancelsRegistered(t *testing.T) {
+	r := newSessionRegistry()
+	canceled := false
+	r.register(1, func() { canceled = true })
+
+	if !r.kill(1) {
+		t.Fatalf("expected kill to find a registered cancel func")
+	}
+	if !canceled {
+		t.Fatalf("expected kill to invoke the cancel func")
+	}
+}
+
+func TestSessionRegistryKillUnknownConnReturnsFalse(t *testing.T) {
+	r := newSessionRegistry()
+	if r.kill(99) {
+		t.Fatalf("expected kill on an unregistered connection id to return false")
+	}
+}
+
+func TestSessionRegistryUnregister(t *testing.T) {
+	r := newSessionRegistry()
+	r.register(1, func() {})
+	r.unregister(1)
+
+	if r.kill(1) {
+		t.Fatalf("expected kill after unregister to return false")
+	}
+}
@@ -0,0 +1,74 @@
+package mysqlfe
+
+import (
+	"time"
+
+	u "github.com/araddon/gou"
+)
+
+// QueryEvent describes one completed query, reported to every registered
+// QueryObserver and, when it crosses models.Config.SlowLogThresholdMs, to
+// the SlowLogSink.
+type QueryEvent struct {
+	Sql        string
+	ClientAddr string
+	Schema     string
+	Backends   []string
+	Rows       int
+	Elapsed    time.Duration
+	Err        error
+}
+
+// SlowLogSink receives one entry per query whose elapsed time crosses the
+// configured threshold. The default sink logs a single line via gou;
+// operators can swap in a file or syslog backed implementation with
+// SetSlowLogSink.
+type SlowLogSink interface {
+	LogSlowQuery(ev QueryEvent)
+}
+
+// QueryObserver receives every completed query, slow or not, so metrics
+// exporters (Prometheus, statsd) can aggregate counts/latencies/errors
+// without parsing log lines.
+type QueryObserver interface {
+	ObserveQuery(ev QueryEvent)
+}
+
+// gouSlowLogSink is the default SlowLogSink.
+type gouSlowLogSink struct{}
+
+func (gouSlowLogSink) LogSlowQuery(ev QueryEvent) {
+	u.Warnf("slow query elapsed=%s rows=%d client=%s schema=%s backends=%v sql=%q",
+		ev.Elapsed, ev.Rows, ev.ClientAddr, ev.Schema, ev.Backends, ev.Sql)
+}
+
+var (
+	slowLogSink    SlowLogSink = gouSlowLogSink{}
+	queryObservers []QueryObserver
+)
+
+// SetSlowLogSink overrides the default stderr-via-gou SlowLogSink.
+// Passing nil restores the default.
+func SetSlowLogSink(sink SlowLogSink) {
+	if sink == nil {
+		sink = gouSlowLogSink{}
+	}
+	slowLogSink = sink
+}
+
+// RegisterQueryObserver adds an observer notified of every completed
+// query, independent of the slow-query threshold.
+func RegisterQueryObserver(o QueryObserver) {
+	queryObservers = append(queryObservers, o)
+}
+
+// reportQuery notifies every QueryObserver, then the SlowLogSink if
+// thresholdMs is set and ev.Elapsed crosses it.
+func reportQuery(ev QueryEvent, thresholdMs int) {
+	for _, o := range queryObservers {
+		o.ObserveQuery(ev)
+	}
+	if thresholdMs > 0 && ev.Elapsed >= time.Duration(thresholdMs)*time.Millisecond {
+		slowLogSink.LogSlowQuery(ev)
+	}
+}